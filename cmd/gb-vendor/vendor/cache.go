@@ -0,0 +1,64 @@
+package vendor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cacheEnvVar overrides the location of the on-disk repository cache.
+const cacheEnvVar = "GB_CACHE"
+
+// CacheDir returns the root of the on-disk repository cache used to
+// speed up repeated Checkouts of the same repository, creating it if
+// necessary. It is $GB_CACHE if set, otherwise
+// $XDG_CACHE_HOME/gb/vendor, falling back to $HOME/.cache/gb/vendor
+// when XDG_CACHE_HOME is unset.
+func CacheDir() (string, error) {
+	dir := os.Getenv(cacheEnvVar)
+	if dir == "" {
+		base := os.Getenv("XDG_CACHE_HOME")
+		if base == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			base = filepath.Join(home, ".cache")
+		}
+		dir = filepath.Join(base, "gb", "vendor")
+	}
+	return dir, mkdir(dir)
+}
+
+// PurgeCache deletes the entire on-disk repository cache, including
+// every mirror held in it.
+func PurgeCache() error {
+	dir, err := CacheDir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// mirrorPath returns the path inside the cache that holds the local
+// mirror of url for the named VCS, creating its parent directory.
+func mirrorPath(vcsName, url string) (string, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, vcsName, sanitize(url))
+	return path, mkdir(filepath.Dir(path))
+}
+
+// sanitize turns a repository URL into something safe to use as a
+// single path component.
+func sanitize(url string) string {
+	r := strings.NewReplacer("://", "-", "/", "-", ":", "-", "@", "-")
+	return r.Replace(url)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
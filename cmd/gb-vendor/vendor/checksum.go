@@ -0,0 +1,110 @@
+package vendor
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// vcsMetaDirs are directory names that hold a VCS's own bookkeeping
+// rather than vendored source, and are excluded from HashTree so the
+// checksum only reflects the content actually vendored, not
+// nondeterministic pack/index state that differs between e.g. a
+// `git worktree add` checkout and a fresh `git clone` of the same
+// revision.
+var vcsMetaDirs = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".svn": true,
+	".bzr": true,
+}
+
+// vcsMetaFiles are analogous to vcsMetaDirs but for VCS bookkeeping
+// that isn't a directory, such as fossil's checkout marker or the
+// `.git` file (as opposed to directory) left by `git worktree add`,
+// whose content is an absolute, run-specific path into the mirror's
+// worktrees directory and so is never reproducible across fetches.
+var vcsMetaFiles = map[string]bool{
+	".git":      true,
+	".hg":       true,
+	".svn":      true,
+	".bzr":      true,
+	".fslckout": true,
+	"_FOSSIL_":  true,
+}
+
+// HashTree computes a deterministic checksum of the files rooted at
+// dir, suitable for storing in Dependency.Checksum and comparing
+// across fetches of the same revision. Files are walked in sorted
+// order and each contributes its slash-separated relative path, mode
+// and content to the hash, so the result only changes when the
+// vendored tree itself changes. VCS metadata directories are
+// excluded, and symlinks contribute their target rather than being
+// followed.
+func HashTree(dir string) (string, error) {
+	var paths []string
+	if err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if rel != "." && vcsMetaDirs[fi.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if vcsMetaFiles[fi.Name()] {
+			return nil
+		}
+		paths = append(paths, rel)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, rel := range paths {
+		full := filepath.Join(dir, rel)
+		fi, err := os.Lstat(full)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, filepath.ToSlash(rel))
+		io.WriteString(h, fi.Mode().String())
+		if fi.Mode()&os.ModeSymlink != 0 {
+			// Hash the link target rather than following it: a
+			// symlink to a directory would otherwise make os.Open
+			// below fail with "is a directory", and following a
+			// symlink to a file would make the checksum depend on
+			// content outside the tree being hashed.
+			target, err := os.Readlink(full)
+			if err != nil {
+				return "", err
+			}
+			io.WriteString(h, target)
+			continue
+		}
+		if err := hashFile(h, full); err != nil {
+			return "", err
+		}
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
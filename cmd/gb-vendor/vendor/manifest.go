@@ -4,15 +4,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 )
 
 // gb-vendor manifest support
 
+const (
+	// ManifestVersionV0 is the original, unversioned manifest layout.
+	// Manifests missing a version, or explicitly at 0, are upgraded
+	// to ManifestVersion in memory when read.
+	ManifestVersionV0 = 0
+
+	// ManifestVersion is the current manifest version written by
+	// WriteManifest.
+	ManifestVersion = 1
+)
+
 // Manfest describes the layout of $PROJECT/vendor/vendorfile.
 type Manifest struct {
-	// Manifest version. Current manifest version is 0.
+	// Manifest version. Current manifest version is 1.
 	Version int `json:"version"`
 
 	// Depenencies is a list of vendored dependencies.
@@ -84,22 +97,51 @@ type Dependency struct {
 	// Path is the path inside the Repository where the
 	// dependency was fetched from.
 	Path string `json:"path"`
+
+	// Checksum is the base64-encoded sha256 tree hash of the vendored
+	// files, in the form returned by HashTree. This package only
+	// stores and loads it with the manifest: nothing here computes it
+	// when a dependency is fetched or compares it on restore.
+	Checksum string `json:"checksum"`
 }
 
 // WriteManifest writes a Manifest to the path. If the manifest does
 // not exist, it is created. If it does exist, it will be overwritten.
-// TODO(dfc) write to temporary file and move atomically to avoid
-// destroying a working vendorfile.
+// The manifest is written to a temporary file in the same directory
+// as path and renamed into place, so a process that dies mid-write
+// cannot leave path holding a truncated manifest.
 func WriteManifest(path string, m *Manifest) error {
-	f, err := os.Create(path)
+	if m.Version == ManifestVersionV0 {
+		m.Version = ManifestVersion
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
 	if err != nil {
 		return err
 	}
+	tmp := f.Name()
+	// ioutil.TempFile creates tmp at mode 0600; os.Create (what this
+	// replaced) would have created it at 0666 less the umask, which
+	// is usually 0644, so set 0644 rather than silently
+	// tightening the vendorfile's permissions on every write.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
 	if err := writeManifest(f, m); err != nil {
 		f.Close()
+		os.Remove(tmp)
 		return err
 	}
-	return f.Close()
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
 }
 
 func writeManifest(w io.Writer, m *Manifest) error {
@@ -108,12 +150,12 @@ func writeManifest(w io.Writer, m *Manifest) error {
 }
 
 // ReadManifest reads a Manifest from path. If the Manifest is not
-// found, a blank Manifest will be returned.
+// found, a blank Manifest at the current version will be returned.
 func ReadManifest(path string) (*Manifest, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return new(Manifest), nil
+			return &Manifest{Version: ManifestVersion}, nil
 		}
 		return nil, err
 	}
@@ -124,6 +166,19 @@ func ReadManifest(path string) (*Manifest, error) {
 func readManifest(r io.Reader) (*Manifest, error) {
 	var m Manifest
 	d := json.NewDecoder(r)
-	err := d.Decode(&m)
-	return &m, err
+	if err := d.Decode(&m); err != nil {
+		return nil, err
+	}
+	switch m.Version {
+	case ManifestVersionV0:
+		// The original manifest format carried no checksums and
+		// never set a version; upgrade it in memory so the rest of
+		// gb-vendor only has to deal with the current layout.
+		m.Version = ManifestVersion
+	case ManifestVersion:
+		// current
+	default:
+		return nil, fmt.Errorf("unsupported manifest version %d: upgrade gb-vendor", m.Version)
+	}
+	return &m, nil
 }
@@ -0,0 +1,161 @@
+package vendor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// metaImportRegex extracts the content of each
+// <meta name="go-import" content="root vcs reporoot"> tag on a page.
+// A page may legitimately serve more than one, one per sub-root it
+// hosts.
+var metaImportRegex = regexp.MustCompile(`<meta\s+name=["']go-import["']\s+content=["']([^"']+)["']\s*/?>`)
+
+// RepoRoot describes the repository that hosts an import path,
+// resolved from that path's go-import meta tag.
+type RepoRoot struct {
+	// Root is the import path prefix corresponding to Repo, e.g.
+	// "example.com/pkg".
+	Root string
+
+	// VCS is the name of the VCS that hosts Repo, as registered
+	// with RegisterVCS, e.g. "git".
+	VCS string
+
+	// Repo is the repository URL, including scheme.
+	Repo string
+}
+
+var (
+	repoRootCacheMu sync.Mutex
+	repoRootCache   = make(map[string]*RepoRoot)
+)
+
+// RepoRootForImportPath resolves path to the repository that hosts
+// it, by fetching its go-import meta tag. The https scheme is always
+// tried first; the http scheme is only tried if that fails and
+// insecure is true, since the metadata returned over plain http
+// cannot be trusted to be unmodified in transit.
+//
+// Results are memoized per-process, keyed by the resolved repository
+// root, so that vendoring several packages that share an import path
+// prefix only queries the network once: the cache is consulted before
+// any network access, both for paths equal to a previously resolved
+// root and for paths nested underneath one.
+func RepoRootForImportPath(path string, insecure bool) (*RepoRoot, error) {
+	if rr := cachedRepoRoot(path); rr != nil {
+		return rr, nil
+	}
+
+	importpath, vcs, reporoot, err := fetchMetaImport(path, insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	repoRootCacheMu.Lock()
+	if rr, ok := repoRootCache[importpath]; ok {
+		repoRootCacheMu.Unlock()
+		return rr, nil
+	}
+	repoRootCacheMu.Unlock()
+
+	v := lookupVCS(vcs)
+	if v == nil {
+		return nil, fmt.Errorf("unknown repository type: %q", vcs)
+	}
+
+	// reporoot may already carry a scheme (the common case, as most
+	// go-import meta tags specify one); only probe the registry's
+	// schemes when it doesn't.
+	repo := reporoot
+	if !strings.Contains(reporoot, "://") {
+		repo = v.probeSchemes(reporoot)
+	}
+
+	rr := &RepoRoot{Root: importpath, VCS: vcs, Repo: repo}
+	repoRootCacheMu.Lock()
+	repoRootCache[importpath] = rr
+	repoRootCacheMu.Unlock()
+	return rr, nil
+}
+
+// cachedRepoRoot returns a previously resolved RepoRoot whose Root is
+// a prefix of path, or nil if the cache holds nothing useful for path
+// yet.
+func cachedRepoRoot(path string) *RepoRoot {
+	repoRootCacheMu.Lock()
+	defer repoRootCacheMu.Unlock()
+	for root, rr := range repoRootCache {
+		if path == root || strings.HasPrefix(path, root+"/") {
+			return rr
+		}
+	}
+	return nil
+}
+
+// ParseMetadata fetches path over https, looking for a go-import meta
+// tag of the form described by `go help importpath`, and returns the
+// import path root, the VCS name and the repository root URL it
+// names.
+func ParseMetadata(path string) (importpath, vcs, reporoot string, err error) {
+	return fetchMetaImport(path, false)
+}
+
+// fetchMetaImport fetches the go-import meta tag for path, preferring
+// https and only falling back to http when insecure is true.
+func fetchMetaImport(path string, insecure bool) (importpath, vcs, reporoot string, err error) {
+	importpath, vcs, reporoot, err = fetchMetaImportScheme(path, "https")
+	if err != nil && insecure {
+		importpath, vcs, reporoot, err = fetchMetaImportScheme(path, "http")
+	}
+	return importpath, vcs, reporoot, err
+}
+
+func fetchMetaImportScheme(path, scheme string) (importpath, vcs, reporoot string, err error) {
+	url := fmt.Sprintf("%s://%s?go-get=1", scheme, path)
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return parseMetaImport(resp.Body, path)
+}
+
+func parseMetaImport(r io.Reader, path string) (importpath, vcs, reporoot string, err error) {
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return "", "", "", err
+	}
+	matches := metaImportRegex.FindAllStringSubmatch(buf.String(), -1)
+	if matches == nil {
+		return "", "", "", fmt.Errorf("no go-import meta tag found for %q", path)
+	}
+	// A page may serve one go-import tag per sub-root; pick the one
+	// whose root is a prefix of path, preferring the longest (most
+	// specific) match, as the go tool does.
+	for _, m := range matches {
+		fields := strings.Fields(m[1])
+		if len(fields) != 3 {
+			return "", "", "", fmt.Errorf("malformed go-import meta tag for %q: %q", path, m[1])
+		}
+		root := fields[0]
+		if root != path && !strings.HasPrefix(path, root+"/") {
+			continue
+		}
+		if len(root) > len(importpath) {
+			importpath, vcs, reporoot = root, fields[1], fields[2]
+		}
+	}
+	if importpath == "" {
+		return "", "", "", fmt.Errorf("go-import root for %q not found among %d meta tag(s)", path, len(matches))
+	}
+	return importpath, vcs, reporoot, nil
+}
@@ -15,11 +15,12 @@ import (
 // RemoteRepo describes a remote dvcs repository.
 type RemoteRepo interface {
 
-	// Checkout checks out the specific branch and revision
+	// Checkout checks out the specific branch, tag or revision.
 	// If branch is empty, the default branch for the underlying
-	// VCS will be used. If revision is empty, the latest available
-	// revision, taking into account branch, will be fetched.
-	Checkout(branch, revision string) (WorkingCopy, error)
+	// VCS will be used. At most one of tag and revision may be
+	// supplied; if both are empty, the latest available revision,
+	// taking into account branch, will be fetched.
+	Checkout(branch, tag, revision string) (WorkingCopy, error)
 
 	// URL returns the URL the clone was taken from. It should
 	// only be called after Clone.
@@ -87,40 +88,38 @@ func DeduceRemoteRepo(path string) (RemoteRepo, string, error) {
 		return repo, v[3], err
 	default:
 		// no idea, try to resolve as a vanity import
-		importpath, vcs, reporoot, err := ParseMetadata(path)
+		rr, err := RepoRootForImportPath(path, false)
 		if err != nil {
 			return nil, "", err
 		}
-		extra := path[len(importpath):]
-		switch vcs {
-		case "git":
-			repo, err := Gitrepo(reporoot)
-			return repo, extra, err
-		case "hg":
-			repo, err := Hgrepo(reporoot)
-			return repo, extra, err
-		case "bzr":
-			repo, err := Bzrrepo(reporoot)
-			return repo, extra, err
-		default:
-			return nil, "", fmt.Errorf("unknown repository type: %q", vcs)
+		extra := path[len(rr.Root):]
+		v := lookupVCS(rr.VCS)
+		if v == nil {
+			return nil, "", fmt.Errorf("unknown repository type: %q", rr.VCS)
 		}
+		repo, err := newRepo(v, rr.Repo)
+		return repo, extra, err
 	}
 }
 
+// newRepo constructs the RemoteRepo implementation appropriate for v,
+// via v.New. This dispatches through the registry rather than a fixed
+// set of names, so a VCS registered by a third party via RegisterVCS
+// can be resolved just like a built-in one.
+func newRepo(v *VCS, url string) (RemoteRepo, error) {
+	if v.New == nil {
+		return nil, fmt.Errorf("vcs %q does not support resolving a RemoteRepo", v.Name)
+	}
+	return v.New(url)
+}
+
 // Gitrepo returns a RemoteRepo representing a remote git repository.
 func Gitrepo(url string) (RemoteRepo, error) {
-	if err := probeGitUrl(url); err != nil {
+	v := lookupVCS("git")
+	if err := v.ping(url); err != nil {
 		return nil, err
 	}
-	return &gitrepo{
-		url: url,
-	}, nil
-}
-
-func probeGitUrl(url string) error {
-	_, err := run("git", "ls-remote", "--exit-code", url, "HEAD")
-	return err
+	return &gitrepo{url: url}, nil
 }
 
 // gitrepo is a git RemoteRepo.
@@ -134,34 +133,58 @@ func (g *gitrepo) URL() string {
 	return g.url
 }
 
-func (g *gitrepo) Checkout(branch, revision string) (WorkingCopy, error) {
+func (g *gitrepo) Checkout(branch, tag, revision string) (WorkingCopy, error) {
+	if err := validateTagRevision(tag, revision); err != nil {
+		return nil, err
+	}
 	dir, err := mktmp()
 	if err != nil {
 		return nil, err
 	}
 
-	args := []string{
-		"clone",
-		g.url,
-		dir,
+	if tag == "" && revision == "" {
+		// Fast path: nothing pins this checkout to a point further
+		// back than the tip of branch (or the default branch), so a
+		// shallow clone avoids pulling history gb will never read,
+		// and avoids the cache entirely.
+		args := []string{"clone", "--depth=1", g.url, dir}
+		if branch != "" {
+			args = append(args, "--branch", branch)
+		}
+		if err := runOut(os.Stderr, "git", args...); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		return &GitClone{Path: dir}, nil
 	}
-	if branch != "" {
-		args = append(args, "--branch", branch)
+
+	v := lookupVCS("git")
+	mirror, err := v.syncMirror(g.url)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
 	}
 
-	if err := runOut(os.Stderr, "git", args...); err != nil {
+	ref := revision
+	if ref == "" {
+		ref = tag
+	}
+	if err := v.checkout(mirror, dir, ref); err != nil {
 		os.RemoveAll(dir)
 		return nil, err
 	}
 
-	return &GitClone{
-		Path: dir,
-	}, nil
+	return &GitClone{Path: dir, Mirror: mirror}, nil
 }
 
 // GitClone is a git WorkingCopy.
 type GitClone struct {
 	Path string
+
+	// Mirror is the cache path of the --mirror clone this working
+	// tree was created from via `git worktree add`. Empty for working
+	// trees created by a plain (non-cached) shallow clone.
+	Mirror string
 }
 
 func (g *GitClone) Dir() string { return g.Path }
@@ -177,6 +200,12 @@ func (g *GitClone) Branch() (string, error) {
 }
 
 func (g *GitClone) Destroy() error {
+	if g.Mirror != "" {
+		// Best effort: detach the worktree from the mirror's
+		// administrative files before removing it. A failure here
+		// just leaves a prunable entry behind in the mirror.
+		runOut(os.Stderr, "git", "-C", g.Mirror, "worktree", "remove", "--force", g.Path)
+	}
 	parent := filepath.Dir(g.Path)
 	if err := os.RemoveAll(g.Path); err != nil {
 		return err
@@ -186,17 +215,11 @@ func (g *GitClone) Destroy() error {
 
 // Hgrepo returns a RemoteRepo representing a remote git repository.
 func Hgrepo(url string) (RemoteRepo, error) {
-	if err := probeHgUrl(url); err != nil {
+	v := lookupVCS("hg")
+	if err := v.ping(url); err != nil {
 		return nil, err
 	}
-	return &hgrepo{
-		url: url,
-	}, nil
-}
-
-func probeHgUrl(url string) error {
-	_, err := run("hg", "identify", url)
-	return err
+	return &hgrepo{url: url}, nil
 }
 
 // hgrepo is a Mercurial repo.
@@ -208,32 +231,53 @@ type hgrepo struct {
 
 func (h *hgrepo) URL() string { return h.url }
 
-func (h *hgrepo) Checkout(branch, revision string) (WorkingCopy, error) {
-	dir, err := mktmp()
-	if err != nil {
+func (h *hgrepo) Checkout(branch, tag, revision string) (WorkingCopy, error) {
+	if err := validateTagRevision(tag, revision); err != nil {
 		return nil, err
 	}
-	args := []string{
-		"clone",
-		h.url,
-		dir,
+	v := lookupVCS("hg")
+	mirror, err := v.syncMirror(h.url)
+	if err != nil {
+		return nil, err
 	}
 
+	dir, err := mktmp()
+	if err != nil {
+		return nil, err
+	}
+	// Materialize the working copy with a local clone from the
+	// mirror (hardlinked, so effectively free) rather than `hg
+	// archive`, so the result is still a real hg working copy and
+	// HgClone.Revision/Branch keep working against it. checkoutCmd
+	// has no {branch} token, since hg's -b flag is only meaningful on
+	// the initial clone and not on every subsequent sync.
+	args := expand(v.checkoutCmd, "{mirror}", mirror)
+	args = expand(args, "{wc}", dir)
 	if branch != "" {
-		args = append(args, "--branch", branch)
+		args = append(args, "-b", branch)
 	}
-	if err := runOut(os.Stderr, "hg", args...); err != nil {
+	if err := runOut(os.Stderr, v.Cmd, args...); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err := v.checkoutRevision(dir, tag, revision); err != nil {
+		os.RemoveAll(dir)
 		return nil, err
 	}
 
 	return &HgClone{
-		Path: dir,
+		Path:   dir,
+		Mirror: mirror,
 	}, nil
 }
 
 // HgClone is a mercurial WorkingCopy.
 type HgClone struct {
 	Path string
+
+	// Mirror is the cache path this working copy was cloned from.
+	Mirror string
 }
 
 func (h *HgClone) Dir() string { return h.Path }
@@ -258,17 +302,11 @@ func (h *HgClone) Destroy() error {
 
 // Bzrrepo returns a RemoteRepo representing a remote bzr repository.
 func Bzrrepo(url string) (RemoteRepo, error) {
-	if err := probeBzrUrl(url); err != nil {
+	v := lookupVCS("bzr")
+	if err := v.ping(url); err != nil {
 		return nil, err
 	}
-	return &bzrrepo{
-		url: url,
-	}, nil
-}
-
-func probeBzrUrl(url string) error {
-	_, err := run("bzr", "info", url)
-	return err
+	return &bzrrepo{url: url}, nil
 }
 
 // bzrrepo is a bzr RemoteRepo.
@@ -282,13 +320,32 @@ func (b *bzrrepo) URL() string {
 	return b.url
 }
 
-func (b *bzrrepo) Checkout(branch, revision string) (WorkingCopy, error) {
+func (b *bzrrepo) Checkout(branch, tag, revision string) (WorkingCopy, error) {
+	if err := validateTagRevision(tag, revision); err != nil {
+		return nil, err
+	}
+	v := lookupVCS("bzr")
+	mirror, err := v.syncMirror(b.url)
+	if err != nil {
+		return nil, err
+	}
+
 	dir, err := mktmp()
 	if err != nil {
 		return nil, err
 	}
 	dir = filepath.Join(dir, "wc")
-	if err := runOut(os.Stderr, "bzr", "branch", b.url, dir); err != nil {
+	// A lightweight checkout shares the mirror's history rather than
+	// copying it, so materializing the working copy is cheap once
+	// the mirror itself is warm.
+	args := expand(v.checkoutCmd, "{mirror}", mirror)
+	args = expand(args, "{wc}", dir)
+	if err := runOut(os.Stderr, v.Cmd, args...); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err := v.checkoutRevision(dir, tag, revision); err != nil {
 		os.RemoveAll(dir)
 		return nil, err
 	}
@@ -306,11 +363,21 @@ type BzrClone struct {
 func (b *BzrClone) Dir() string { return b.Path }
 
 func (b *BzrClone) Revision() (string, error) {
-	return "1", nil
+	// On a lightweight checkout, plain `bzr revno` reports the revno
+	// of the shared branch (its tip), not of this working tree, so a
+	// checkout pinned to an older revision via tagSyncCmd's `bzr
+	// revert -r N` would otherwise report N's tip instead of N.
+	// --tree asks for the working tree's last revision instead.
+	rev, err := run("bzr", "revno", "--tree", b.Path)
+	return strings.TrimSpace(string(rev)), err
 }
 
 func (b *BzrClone) Branch() (string, error) {
-	return "master", nil
+	// bzr nick with an argument *sets* the nickname rather than
+	// reading it; run it with no arguments inside the checkout to
+	// read the current one instead.
+	nick, err := runInCapture(b.Path, "bzr", "nick")
+	return strings.TrimSpace(string(nick)), err
 }
 
 func (b *BzrClone) Destroy() error {
@@ -321,6 +388,175 @@ func (b *BzrClone) Destroy() error {
 	return cleanPath(parent)
 }
 
+// Svnrepo returns a RemoteRepo representing a remote Subversion repository.
+func Svnrepo(url string) (RemoteRepo, error) {
+	v := lookupVCS("svn")
+	if err := v.ping(url); err != nil {
+		return nil, err
+	}
+	return &svnrepo{url: url}, nil
+}
+
+// svnrepo is a Subversion RemoteRepo.
+type svnrepo struct {
+
+	// remote repository url, see man 1 svn
+	url string
+}
+
+func (s *svnrepo) URL() string { return s.url }
+
+func (s *svnrepo) Checkout(branch, tag, revision string) (WorkingCopy, error) {
+	if err := validateTagRevision(tag, revision); err != nil {
+		return nil, err
+	}
+	v := lookupVCS("svn")
+	dir, err := mktmp()
+	if err != nil {
+		return nil, err
+	}
+	url := s.url
+	switch {
+	case branch != "":
+		url = strings.TrimSuffix(url, "/") + "/" + branch
+	case tag != "":
+		// Subversion has no notion of a "tag" distinct from a
+		// branch; by convention tags live as paths under the repo,
+		// so treat tag the same way as branch.
+		url = strings.TrimSuffix(url, "/") + "/" + tag
+	}
+	args := expand(v.createCmd, "{repo}", url)
+	args = expand(args, "{dir}", dir)
+	if err := runOut(os.Stderr, v.Cmd, args...); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err := v.checkoutRevision(dir, "", revision); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &SvnClone{
+		Path: dir,
+	}, nil
+}
+
+// SvnClone is a Subversion WorkingCopy.
+type SvnClone struct {
+	Path string
+}
+
+func (s *SvnClone) Dir() string { return s.Path }
+
+func (s *SvnClone) Revision() (string, error) {
+	out, err := run("svnversion", s.Path)
+	return strings.TrimSpace(string(out)), err
+}
+
+func (s *SvnClone) Branch() (string, error) {
+	return "", nil
+}
+
+func (s *SvnClone) Destroy() error {
+	parent := filepath.Dir(s.Path)
+	if err := os.RemoveAll(s.Path); err != nil {
+		return err
+	}
+	return cleanPath(parent)
+}
+
+// Fossilrepo returns a RemoteRepo representing a remote Fossil repository.
+func Fossilrepo(url string) (RemoteRepo, error) {
+	v := lookupVCS("fossil")
+	if err := v.ping(url); err != nil {
+		return nil, err
+	}
+	return &fossilrepo{url: url}, nil
+}
+
+// fossilrepo is a Fossil RemoteRepo.
+type fossilrepo struct {
+
+	// remote repository url, see fossil(1) clone
+	url string
+}
+
+func (f *fossilrepo) URL() string { return f.url }
+
+func (f *fossilrepo) Checkout(branch, tag, revision string) (WorkingCopy, error) {
+	if err := validateTagRevision(tag, revision); err != nil {
+		return nil, err
+	}
+	v := lookupVCS("fossil")
+	dir, err := mktmp()
+	if err != nil {
+		return nil, err
+	}
+	args := expand(v.createCmd, "{repo}", f.url)
+	args = expand(args, "{dir}", dir)
+	if err := runOut(os.Stderr, v.Cmd, args...); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	checkoutArgs := []string{"open", filepath.Join(dir, ".fossil")}
+	if branch != "" {
+		checkoutArgs = append(checkoutArgs, branch)
+	}
+	if err := runIn(dir, os.Stderr, v.Cmd, checkoutArgs...); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	if err := v.checkoutRevision(dir, tag, revision); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &FossilClone{
+		Path: dir,
+	}, nil
+}
+
+// FossilClone is a Fossil WorkingCopy.
+type FossilClone struct {
+	Path string
+}
+
+func (f *FossilClone) Dir() string { return f.Path }
+
+func (f *FossilClone) Revision() (string, error) {
+	// `fossil info` reports on the checkout open in the current
+	// directory; it does not take a directory as an argument, so it
+	// must be run with its cwd set to the checkout. Fossil support is
+	// best-effort and has not been verified against a live server.
+	out, err := runInCapture(f.Path, "fossil", "info")
+	if err != nil {
+		return "", err
+	}
+	// The "checkout:" line holds the checked-out artifact hash,
+	// followed by its timestamp.
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "checkout:" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("fossil info: no checkout line in output for %s", f.Path)
+}
+
+func (f *FossilClone) Branch() (string, error) {
+	return "", nil
+}
+
+func (f *FossilClone) Destroy() error {
+	parent := filepath.Dir(f.Path)
+	if err := os.RemoveAll(f.Path); err != nil {
+		return err
+	}
+	return cleanPath(parent)
+}
+
 func cleanPath(path string) error {
 	if files, _ := ioutil.ReadDir(path); len(files) > 0 || filepath.Base(path) == "src" {
 		return nil
@@ -346,9 +582,23 @@ func run(c string, args ...string) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
+func runInCapture(dir, c string, args ...string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := runIn(dir, &buf, c, args...)
+	return buf.Bytes(), err
+}
+
 func runOut(w io.Writer, c string, args ...string) error {
 	cmd := exec.Command(c, args...)
 	cmd.Stdout = w
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+func runIn(dir string, w io.Writer, c string, args ...string) error {
+	cmd := exec.Command(c, args...)
+	cmd.Dir = dir
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,238 @@
+package vendor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VCS describes how gb-vendor drives a particular version control
+// tool to create, update and identify a WorkingCopy. The set of known
+// VCS backends is table-driven, loosely modelled on the vcs table in
+// cmd/go/internal/get, so that adding another backend (or letting a
+// third party add one via RegisterVCS) does not require touching
+// DeduceRemoteRepo or the RemoteRepo/WorkingCopy implementations.
+type VCS struct {
+	// Name identifies the VCS, e.g. "git", "svn".
+	Name string
+
+	// Cmd is the binary used to talk to repositories of this type.
+	Cmd string
+
+	// Schemes lists the URL schemes this VCS can fetch over, in the
+	// order they should be tried when a scheme is not already known.
+	Schemes []string
+
+	// New constructs the RemoteRepo for a repository URL hosted on
+	// this VCS. Third parties registering a VCS via RegisterVCS must
+	// set this for newRepo/DeduceRemoteRepo to be able to produce a
+	// RemoteRepo for it.
+	New func(url string) (RemoteRepo, error)
+
+	// pingCmd is the argument list used to test whether {repo} is a
+	// reachable repository of this type, without cloning it.
+	pingCmd []string
+
+	// createCmd is the argument list used to clone {repo} directly
+	// into {dir}. Used by VCS backends with no on-disk mirror cache
+	// (currently svn and fossil).
+	createCmd []string
+
+	// mirrorMarker is a path, relative to a cache mirror directory,
+	// whose existence indicates the mirror has already been created.
+	mirrorMarker string
+
+	// mirrorCreateCmd creates a local mirror of {repo} at {dir} in
+	// the repo cache, the first time a URL is seen.
+	mirrorCreateCmd []string
+
+	// mirrorUpdateCmd refreshes an existing mirror at {dir} from its
+	// origin.
+	mirrorUpdateCmd []string
+
+	// checkoutCmd materializes a working copy at {wc} from the
+	// mirror at {mirror}.
+	checkoutCmd []string
+
+	// tagSyncCmd is the argument list used to move an existing
+	// working copy in {dir} to {rev} (a revision or tag).
+	tagSyncCmd []string
+}
+
+// registry is the set of known VCS backends, keyed by name.
+var registry = make(map[string]*VCS)
+
+// RegisterVCS adds v to the set of VCS backends gb-vendor knows how
+// to drive. It is intended to let third parties teach gb-vendor about
+// version control systems it does not support out of the box.
+// Registering a VCS with a name that is already known replaces it.
+func RegisterVCS(v *VCS) {
+	registry[v.Name] = v
+}
+
+func init() {
+	RegisterVCS(&VCS{
+		Name:            "git",
+		Cmd:             "git",
+		Schemes:         []string{"https", "http", "git", "ssh"},
+		New:             Gitrepo,
+		pingCmd:         []string{"ls-remote", "--exit-code", "{repo}", "HEAD"},
+		mirrorMarker:    "HEAD",
+		mirrorCreateCmd: []string{"clone", "--mirror", "{repo}", "{dir}"},
+		mirrorUpdateCmd: []string{"-C", "{dir}", "fetch", "--prune"},
+		checkoutCmd:     []string{"-C", "{mirror}", "worktree", "add", "--detach", "{wc}", "{rev}"},
+	})
+	RegisterVCS(&VCS{
+		Name:            "hg",
+		Cmd:             "hg",
+		Schemes:         []string{"https", "http", "ssh"},
+		New:             Hgrepo,
+		pingCmd:         []string{"identify", "{repo}"},
+		mirrorMarker:    ".hg",
+		mirrorCreateCmd: []string{"clone", "-U", "{repo}", "{dir}"},
+		mirrorUpdateCmd: []string{"--cwd", "{dir}", "pull"},
+		checkoutCmd:     []string{"clone", "{mirror}", "{wc}"},
+		tagSyncCmd:      []string{"--cwd", "{dir}", "update", "-r", "{rev}"},
+	})
+	RegisterVCS(&VCS{
+		Name:            "bzr",
+		Cmd:             "bzr",
+		Schemes:         []string{"https", "http", "bzr", "bzr+ssh"},
+		New:             Bzrrepo,
+		pingCmd:         []string{"info", "{repo}"},
+		mirrorMarker:    ".bzr",
+		mirrorCreateCmd: []string{"branch", "{repo}", "{dir}"},
+		mirrorUpdateCmd: []string{"pull", "-d", "{dir}"},
+		checkoutCmd:     []string{"checkout", "--lightweight", "{mirror}", "{wc}"},
+		tagSyncCmd:      []string{"revert", "-r", "{rev}", "-d", "{dir}"},
+	})
+	RegisterVCS(&VCS{
+		Name:       "svn",
+		Cmd:        "svn",
+		Schemes:    []string{"https", "http", "svn", "svn+ssh"},
+		New:        Svnrepo,
+		pingCmd:    []string{"info", "{repo}"},
+		createCmd:  []string{"checkout", "{repo}", "{dir}"},
+		tagSyncCmd: []string{"update", "-r", "{rev}", "{dir}"},
+	})
+	RegisterVCS(&VCS{
+		Name:    "fossil",
+		Cmd:     "fossil",
+		Schemes: []string{"https", "http"},
+		New:     Fossilrepo,
+		// fossil has no equivalent of `git ls-remote` to probe a
+		// remote without cloning it; `remote-url` only reports the
+		// remote configured for an already-open local checkout, so
+		// this does not actually verify reachability. Fossil support
+		// is best-effort and not verified against a live server.
+		pingCmd:    []string{"remote-url", "{repo}"},
+		createCmd:  []string{"clone", "{repo}", "{dir}/.fossil"},
+		tagSyncCmd: []string{"update", "{rev}"},
+	})
+}
+
+// lookupVCS returns the registered VCS with the given name, or nil if
+// none is registered.
+func lookupVCS(name string) *VCS {
+	return registry[name]
+}
+
+// ping reports whether repo looks like a reachable v.Name repository,
+// by running v.pingCmd with {repo} substituted for repo.
+func (v *VCS) ping(repo string) error {
+	_, err := run(v.Cmd, expand(v.pingCmd, "{repo}", repo)...)
+	return err
+}
+
+// probeSchemes tries each of v's supported schemes against host+path,
+// in order, returning the first URL that pings successfully. If none
+// ping successfully, the first scheme is returned so callers still
+// have something to try.
+func (v *VCS) probeSchemes(hostpath string) string {
+	var first string
+	for i, scheme := range v.Schemes {
+		url := fmt.Sprintf("%s://%s", scheme, hostpath)
+		if i == 0 {
+			first = url
+		}
+		if v.ping(url) == nil {
+			return url
+		}
+	}
+	return first
+}
+
+// expand substitutes occurrences of old with new in each element of
+// args, returning a new slice.
+func expand(args []string, old, new string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = strings.Replace(a, old, new, -1)
+	}
+	return out
+}
+
+// validateTagRevision reports an error if both tag and revision are
+// supplied, since they are mutually exclusive ways of pinning a
+// checkout to a single point in the repository's history.
+func validateTagRevision(tag, revision string) error {
+	if tag != "" && revision != "" {
+		return fmt.Errorf("at most one of tag or revision may be specified, got tag %q and revision %q", tag, revision)
+	}
+	return nil
+}
+
+// checkoutRevision moves the working copy in dir to tag or revision,
+// if either is supplied, using v.tagSyncCmd. It is a no-op if both are
+// empty.
+func (v *VCS) checkoutRevision(dir, tag, revision string) error {
+	ref := revision
+	if ref == "" && tag != "" {
+		if v.Name == "bzr" {
+			ref = "tag:" + tag
+		} else {
+			ref = tag
+		}
+	}
+	if ref == "" {
+		return nil
+	}
+	args := expand(v.tagSyncCmd, "{dir}", dir)
+	args = expand(args, "{rev}", ref)
+	return runIn(dir, os.Stderr, v.Cmd, args...)
+}
+
+// syncMirror ensures the on-disk repo cache holds a mirror of url for
+// this VCS, creating it via mirrorCreateCmd the first time url is
+// seen and refreshing it via mirrorUpdateCmd thereafter, and returns
+// the mirror's path.
+func (v *VCS) syncMirror(url string) (string, error) {
+	mirror, err := mirrorPath(v.Name, url)
+	if err != nil {
+		return "", err
+	}
+	if exists(filepath.Join(mirror, v.mirrorMarker)) {
+		args := expand(v.mirrorUpdateCmd, "{dir}", mirror)
+		if err := runOut(os.Stderr, v.Cmd, args...); err != nil {
+			return "", err
+		}
+		return mirror, nil
+	}
+	args := expand(v.mirrorCreateCmd, "{repo}", url)
+	args = expand(args, "{dir}", mirror)
+	if err := runOut(os.Stderr, v.Cmd, args...); err != nil {
+		os.RemoveAll(mirror)
+		return "", err
+	}
+	return mirror, nil
+}
+
+// checkout materializes a working copy at wc from the mirror at
+// mirror, substituting rev (which may be empty) into checkoutCmd.
+func (v *VCS) checkout(mirror, wc, rev string) error {
+	args := expand(v.checkoutCmd, "{mirror}", mirror)
+	args = expand(args, "{wc}", wc)
+	args = expand(args, "{rev}", rev)
+	return runOut(os.Stderr, v.Cmd, args...)
+}